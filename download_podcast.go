@@ -5,10 +5,15 @@
 package main
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"net/http"
@@ -19,13 +24,22 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
-	_ "crypto/sha512"
+	id3v2 "github.com/bogem/id3v2/v2"
 )
 
 const (
 	seenSubdir     = ".seen" // dest dir subdir for tracking already-downloaded files
 	maxFilenameLen = 255     // max length for path components
+
+	tempSuffix = ".part" // suffix used for in-progress downloads before they're renamed
+
+	// rateLimitInterval is how often a single host is permitted to start a
+	// download when more than one episode resolves to the same host.
+	rateLimitInterval = 500 * time.Millisecond
 )
 
 func getMatch(re, s string) (string, error) {
@@ -40,57 +54,216 @@ func getMatch(re, s string) (string, error) {
 	return string(m), nil
 }
 
-func openURL(u string) (io.ReadCloser, error) {
-	resp, err := http.Get(u)
+// fetchURL issues a GET request for u, returning an error if it can't be
+// started or doesn't return a 200 response. The caller is responsible for
+// closing the returned response's body.
+func fetchURL(u string) (*http.Response, error) {
+	return fetchURLRange(u, 0)
+}
+
+// fetchURLRange is like fetchURL, but if resumeFrom is greater than 0, it
+// requests that the server resume the transfer at that byte offset via a
+// Range header. A 206 response is only accepted if resumeFrom was nonzero;
+// a 200 response means the server is sending (or doesn't support resuming
+// and is resending) the entire body starting from byte 0.
+func fetchURLRange(u string, resumeFrom int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %v: %v", u, err)
+	}
+	if resumeFrom > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeFrom))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch %v: %v", u, err)
-	} else if resp.StatusCode != 200 {
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusPartialContent && resumeFrom > 0:
+	default:
 		resp.Body.Close()
 		return nil, fmt.Errorf("server returned %v for %v", resp.StatusCode, u)
 	}
+	return resp, nil
+}
+
+func openURL(u string) (io.ReadCloser, error) {
+	resp, err := fetchURL(u)
+	if err != nil {
+		return nil, err
+	}
 	return resp.Body, nil
 }
 
-type item struct{ guid, url, title string }
+type item struct {
+	guid, url, title, mimeType, description string
+	pubDate                                 time.Time
+	season, episode                         int   // from itunes:season and itunes:episode, or 0 if absent
+	length                                  int64 // enclosure's declared size in bytes, or 0 if absent
+
+	// hashAlgo and hashValue come from a media:hash element, e.g.
+	// <media:hash algo="sha-512">...</media:hash>. hashAlgo is empty if the
+	// item didn't declare one.
+	hashAlgo, hashValue string
+}
+
+// channelInfo holds feed-level (as opposed to per-episode) metadata, used
+// for ID3 tagging.
+type channelInfo struct {
+	title, author, imageURL string
+}
+
+// mimeExts maps enclosure/Content-Type MIME types to the filename extension
+// (without a leading dot) that should be used for the downloaded file.
+var mimeExts = map[string]string{
+	"audio/mpeg":      "mp3",
+	"audio/mp3":       "mp3",
+	"audio/mp4":       "m4a",
+	"audio/x-m4a":     "m4a",
+	"audio/aac":       "aac",
+	"audio/ogg":       "ogg",
+	"audio/opus":      "opus",
+	"audio/wav":       "wav",
+	"audio/x-wav":     "wav",
+	"audio/flac":      "flac",
+	"video/mp4":       "mp4",
+	"video/quicktime": "mov",
+	"video/webm":      "webm",
+}
+
+// extForMIME returns the filename extension (without a leading dot) for
+// mimeType, or "" if it isn't recognized. Any parameters (e.g.
+// "audio/mpeg; charset=...") are ignored.
+func extForMIME(mimeType string) string {
+	if i := strings.IndexByte(mimeType, ';'); i != -1 {
+		mimeType = mimeType[:i]
+	}
+	return mimeExts[strings.TrimSpace(strings.ToLower(mimeType))]
+}
+
+// pubDateLayouts are the pubDate formats we try when parsing an RSS item,
+// roughly in order of how commonly feeds actually use them (real-world feeds
+// are inconsistent about the RFC822 "Z" vs. numeric offset and about
+// including seconds).
+var pubDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+}
 
-func getItems(feed string) ([]item, error) {
+// parsePubDate parses an RSS pubDate using whichever of pubDateLayouts
+// matches. It returns the zero time.Time if s doesn't match any of them.
+func parsePubDate(s string) time.Time {
+	for _, layout := range pubDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// getItems fetches and parses feed, returning the channel-level metadata
+// along with its episodes.
+func getItems(feed string) (channelInfo, []item, error) {
 	body, err := openURL(feed)
 	if err != nil {
-		return nil, err
+		return channelInfo{}, nil, err
 	}
 	defer body.Close()
 
 	d := xml.NewDecoder(body)
 	d.Strict = false
 
+	var channel channelInfo
+	var sawItem bool // true once the first <item> has been seen
+
 	var items []item
-	var inGUID, inTitle bool
-	var guid, title, url string
+	var inGUID, inTitle, inPubDate, inSeason, inEpisode, inDesc, inHash bool
+	var guid, title, url, mimeType, pubDate, season, episode, desc string
+	var length int64
+	var hashAlgo, hashValue string
+
+	var inChTitle, inChAuthor, inChImage bool
 
 	for {
 		t, err := d.Token()
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return nil, err
+			return channelInfo{}, nil, err
 		}
 
 		switch e := t.(type) {
 		case xml.StartElement:
 			switch e.Name.Local {
 			case "item":
+				sawItem = true
 				guid = ""
 				title = ""
 				url = ""
+				mimeType = ""
+				pubDate = ""
+				season = ""
+				episode = ""
+				desc = ""
+				length = 0
+				hashAlgo = ""
+				hashValue = ""
 			case "guid":
 				inGUID = true
 			case "title":
-				inTitle = true
+				if sawItem {
+					inTitle = true
+				} else {
+					inChTitle = true
+				}
+			case "description":
+				if sawItem {
+					inDesc = true
+				}
+			case "pubDate":
+				inPubDate = true
+			case "itunes:season", "season":
+				inSeason = true
+			case "itunes:episode", "episode":
+				inEpisode = true
+			case "itunes:author", "author":
+				if !sawItem {
+					inChAuthor = true
+				}
+			case "itunes:image":
+				if !sawItem {
+					for _, a := range e.Attr {
+						if a.Name.Local == "href" {
+							channel.imageURL = a.Value
+						}
+					}
+				}
+			case "url":
+				if !sawItem {
+					inChImage = true
+				}
 			case "media:content", "enclosure":
 				for _, a := range e.Attr {
-					if a.Name.Local == "url" {
+					switch a.Name.Local {
+					case "url":
 						url = a.Value
-						break
+					case "type":
+						mimeType = a.Value
+					case "length":
+						length, _ = strconv.ParseInt(a.Value, 10, 64)
+					}
+				}
+			case "media:hash":
+				inHash = true
+				for _, a := range e.Attr {
+					if a.Name.Local == "algo" {
+						hashAlgo = a.Value
 					}
 				}
 			}
@@ -102,12 +275,40 @@ func getItems(feed string) ([]item, error) {
 					if guid == "" {
 						guid = url
 					}
-					items = append(items, item{guid, url, title})
+					it := item{
+						guid:        guid,
+						url:         url,
+						title:       title,
+						mimeType:    mimeType,
+						description: desc,
+						pubDate:     parsePubDate(pubDate),
+						length:      length,
+						hashAlgo:    hashAlgo,
+						hashValue:   hashValue,
+					}
+					it.season, _ = strconv.Atoi(season)
+					it.episode, _ = strconv.Atoi(episode)
+					items = append(items, it)
 				}
 			case "guid":
 				inGUID = false
 			case "title":
 				inTitle = false
+				inChTitle = false
+			case "description":
+				inDesc = false
+			case "pubDate":
+				inPubDate = false
+			case "itunes:season", "season":
+				inSeason = false
+			case "itunes:episode", "episode":
+				inEpisode = false
+			case "itunes:author", "author":
+				inChAuthor = false
+			case "url":
+				inChImage = false
+			case "media:hash":
+				inHash = false
 			}
 
 		case xml.CharData:
@@ -116,11 +317,27 @@ func getItems(feed string) ([]item, error) {
 				guid = string(e)
 			case inTitle:
 				title = string(e)
+			case inChTitle:
+				channel.title = string(e)
+			case inDesc:
+				desc = string(e)
+			case inPubDate:
+				pubDate = string(e)
+			case inSeason:
+				season = string(e)
+			case inEpisode:
+				episode = string(e)
+			case inChAuthor:
+				channel.author = string(e)
+			case inChImage:
+				channel.imageURL = string(e)
+			case inHash:
+				hashValue = string(e)
 			}
 		}
 	}
 
-	return items, nil
+	return channel, items, nil
 }
 
 // Simplecast uses bullshit URLs like the following:
@@ -128,19 +345,164 @@ func getItems(feed string) ([]item, error) {
 // Grab the episode ID so we don't try to name everything default.mp3.
 var episodeIDRegexp = regexp.MustCompile(`/episodes/([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})/`)
 
-func downloadItem(item item, destDir, feed, prefix string, verbose, skipDownload bool) error {
+// pathClaimer reserves destination filenames so that concurrent downloadItem
+// calls never decide on the same destPath: the check-then-act of stat'ing a
+// candidate path and then, later, creating it is otherwise a race between
+// worker goroutines.
+type pathClaimer struct {
+	mu      sync.Mutex
+	claimed map[string]bool
+}
+
+func newPathClaimer() *pathClaimer {
+	return &pathClaimer{claimed: make(map[string]bool)}
+}
+
+// claim reserves and returns an unused path for prefix+base within dir,
+// appending a number before base's extension (as downloadItem's caller used
+// to do inline) if that name is already claimed or already exists on disk.
+func (c *pathClaimer) claim(dir, prefix, base string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reserveLocked(filepath.Join(dir, prefix+base))
+}
+
+// reclaim releases oldPath (previously returned by claim or reclaim) and
+// reserves newPath in its place, falling back to a numbered suffix (as claim
+// does) if newPath is already claimed or exists on disk. It's used when a
+// later step, such as sniffing a response's real extension, needs to change
+// a path after claim already reserved the original one.
+func (c *pathClaimer) reclaim(oldPath, newPath string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.claimed, oldPath)
+	return c.reserveLocked(newPath)
+}
+
+// reserveLocked returns p if it's unclaimed and doesn't already exist on
+// disk, appending a number before p's extension until it finds a path that
+// is. c.mu must already be held.
+func (c *pathClaimer) reserveLocked(p string) string {
+	try := func(p string) bool {
+		if c.claimed[p] {
+			return false
+		}
+		if _, err := os.Stat(p); err == nil {
+			return false
+		}
+		c.claimed[p] = true
+		return true
+	}
+
+	if try(p) {
+		return p
+	}
+	ext := filepath.Ext(p)
+	start := p[:len(p)-len(ext)]
+	for i := 0; ; i++ {
+		p := start + strconv.Itoa(i) + ext
+		if try(p) {
+			return p
+		}
+	}
+}
+
+// hostLimiter is a simple per-host token-bucket rate limiter used to avoid
+// hammering a single CDN when many episodes in a feed resolve to the same
+// host.
+type hostLimiter struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newHostLimiter() *hostLimiter {
+	return &hostLimiter{last: make(map[string]time.Time)}
+}
+
+// wait blocks until it's been at least rateLimitInterval since the last
+// download from u's host was permitted to start.
+func (l *hostLimiter) wait(u string) {
+	host := u
+	if parsed, err := url.Parse(u); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+
+	// Compute how long this call needs to sleep (and reserve the next slot
+	// for host) while holding the lock, but release it before actually
+	// sleeping: the lock guards the map, not the clock, and holding it
+	// across time.Sleep would serialize every host behind whichever one
+	// happens to be waiting, not just the one being rate-limited.
+	l.mu.Lock()
+	now := time.Now()
+	var wait time.Duration
+	if last, ok := l.last[host]; ok {
+		if d := rateLimitInterval - now.Sub(last); d > 0 {
+			wait = d
+		}
+	}
+	l.last[host] = now.Add(wait)
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// downloadItemOpts bundles the options that control how downloadItem
+// fetches and names an episode. It's grown too large to keep as positional
+// downloadItem arguments.
+type downloadItemOpts struct {
+	destDir, feed, prefix string
+	verbose, skipDownload bool
+	limiter               *hostLimiter
+	nameTemplate          *template.Template
+	claimer               *pathClaimer
+
+	tag          bool // write ID3v2 tags to downloaded MP3s
+	channel      channelInfo
+	channelImage []byte // channel's cover art, or nil
+}
+
+func downloadItem(item item, opts downloadItemOpts) error {
+	destDir, feed, prefix := opts.destDir, opts.feed, opts.prefix
+	verbose, skipDownload, limiter, nameTemplate := opts.verbose, opts.skipDownload, opts.limiter, opts.nameTemplate
+
 	base := path.Base(item.url)
 	if i := strings.IndexByte(base, '?'); i != -1 {
 		base = base[:i]
 	}
 
+	// Prefer the extension from the enclosure's declared MIME type over
+	// whatever path.Base gave us (or the mp3 we'd otherwise assume below),
+	// since feeds serving .m4a, .ogg, .opus, .wav, or video episodes often
+	// use URLs that don't end in a sensible extension.
+	ext := extForMIME(item.mimeType)
+
 	// If this is a crappy Simplecast URL, use the title from the feed if we have it
 	// before falling back to the UUID.
 	if m := episodeIDRegexp.FindStringSubmatch(item.url); m != nil {
+		if ext == "" {
+			ext = "mp3"
+		}
 		if item.title != "" {
-			base = item.title + ".mp3"
+			base = item.title + "." + ext
 		} else {
-			base = m[1] + ".mp3"
+			base = m[1] + "." + ext
+		}
+	} else if ext != "" {
+		base = strings.TrimSuffix(base, filepath.Ext(base)) + "." + ext
+	}
+	if ext == "" {
+		ext = strings.TrimPrefix(filepath.Ext(base), ".")
+	}
+
+	if nameTemplate != nil {
+		if name, err := renderNameTemplate(nameTemplate, item, ext); err != nil {
+			if verbose {
+				log.Printf("Failed to render name template for %v: %v", item.url, err)
+			}
+		} else if name != "" {
+			base = name
 		}
 	}
 
@@ -169,32 +531,35 @@ func downloadItem(item item, destDir, feed, prefix string, verbose, skipDownload
 		}
 	}
 
-	destPath := filepath.Join(destDir, prefix+base)
-	if _, err := os.Stat(destPath); err == nil {
-		// If the base filename already exists, append a number to its pre-extension part.
-		ext := filepath.Ext(base)
-		start := base[:len(base)-len(ext)]
-		for i := 0; i >= 0; i++ {
-			destPath = filepath.Join(destDir, prefix+start+strconv.Itoa(i)+ext)
-			if _, err := os.Stat(destPath); err != nil {
-				break // found an unused filename
-			}
-		}
-	}
+	destPath := opts.claimer.claim(destDir, prefix, base)
 
 	if skipDownload {
 		if verbose {
 			log.Printf("Skipping download of %v (%v) to %v", item.url, item.title, destPath)
 		}
 	} else {
+		if limiter != nil {
+			limiter.wait(item.url)
+		}
 		if verbose {
 			log.Printf("Downloading %v (%v) to %v", item.url, item.title, destPath)
 		}
-		if err := download(item.url, destPath); err != nil {
+		verify := downloadVerify{length: item.length, hashAlgo: item.hashAlgo, hashValue: item.hashValue}
+		actualPath, err := download(item.url, destPath, item.mimeType == "", opts.claimer, verify)
+		if err != nil {
 			return err
 		}
+
+		if opts.tag && strings.EqualFold(filepath.Ext(actualPath), ".mp3") {
+			if err := tagMP3(actualPath, item, opts.channel, opts.channelImage); err != nil {
+				log.Printf("Failed to tag %v: %v", actualPath, err)
+			}
+		}
 	}
 
+	// Only mark the item as seen once the download (or rename, for a real
+	// download) has completed successfully, so a crash mid-download doesn't
+	// cause the episode to be skipped on the next run.
 	if verbose {
 		log.Printf("Touching %v", seenPath)
 	}
@@ -210,6 +575,41 @@ func escape(fn string) string {
 	return esc
 }
 
+// badFilenameCharRegexp matches characters that aren't safe to put directly
+// into a path component.
+var badFilenameCharRegexp = regexp.MustCompile(`[/\\:*?"<>|\x00-\x1f]`)
+
+// sanitizeName is like escape but preserves spaces and dashes, for use on
+// strings (e.g. rendered from -name-template) that are meant to stay
+// human-readable.
+func sanitizeName(fn string) string {
+	fn = badFilenameCharRegexp.ReplaceAllString(strings.TrimSpace(fn), "_")
+	if len(fn) > maxFilenameLen {
+		fn = fn[:maxFilenameLen]
+	}
+	return fn
+}
+
+// nameTemplateData holds the fields available to a -name-template template.
+type nameTemplateData struct {
+	Date    time.Time
+	Season  int
+	Episode int
+	Title   string
+	Ext     string
+}
+
+// renderNameTemplate executes tmpl against it and ext, returning the
+// sanitized result.
+func renderNameTemplate(tmpl *template.Template, it item, ext string) (string, error) {
+	var buf strings.Builder
+	data := nameTemplateData{Date: it.pubDate, Season: it.season, Episode: it.episode, Title: it.title, Ext: ext}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return sanitizeName(buf.String()), nil
+}
+
 // touch creates an empty file at p.
 func touch(p string) error {
 	f, err := os.Create(p)
@@ -219,48 +619,452 @@ func touch(p string) error {
 	return f.Close()
 }
 
-// download downloads url to p.
-func download(url, p string) error {
-	body, err := openURL(url)
+// downloadVerify describes the feed-provided information that download uses
+// to check that a completed download is intact.
+type downloadVerify struct {
+	length              int64 // expected size in bytes, or 0 if unknown
+	hashAlgo, hashValue string
+}
+
+// download downloads srcURL to p, resuming a previous attempt if a .part
+// file already exists alongside p. It writes to that temporary file and
+// renames it into place only once the transfer finishes cleanly and passes
+// any checks in verify, so a crash, a truncated transfer, or a corrupt
+// download never leaves p looking like a complete file.
+//
+// If sniffExt is true (the feed didn't declare an enclosure MIME type), and
+// the response's Content-Type header maps to a known extension, p's
+// extension is replaced with it, re-reserving the adjusted path through
+// claimer so that it can't collide with a path claimed for another item
+// whose pre-sniff name happened to coincide; the (possibly adjusted) path
+// that the file was actually written to is returned.
+func download(srcURL, p string, sniffExt bool, claimer *pathClaimer, verify downloadVerify) (string, error) {
+	tempPath := p + tempSuffix
+	var resumeFrom int64
+	if fi, err := os.Stat(tempPath); err == nil {
+		resumeFrom = fi.Size()
+	}
+
+	resp, err := fetchURLRange(srcURL, resumeFrom)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer body.Close()
+	defer resp.Body.Close()
 
-	f, err := os.Create(p)
+	if sniffExt {
+		if ext := extForMIME(resp.Header.Get("Content-Type")); ext != "" {
+			if newP := strings.TrimSuffix(p, filepath.Ext(p)) + "." + ext; newP != p {
+				newP = claimer.reclaim(p, newP)
+				// The extension (and possibly the path, if reclaim had to
+				// bump it to avoid a collision) changed, so any .part file
+				// we found belongs to a different name and can't be resumed
+				// from.
+				p = newP
+				tempPath = p + tempSuffix
+				resumeFrom = 0
+			}
+		}
+	}
+
+	var f *os.File
+	if resp.StatusCode == http.StatusPartialContent {
+		f, err = os.OpenFile(tempPath, os.O_WRONLY|os.O_APPEND, 0644)
+	} else {
+		// The server sent (or resent) the whole body, so make sure we don't
+		// append it after stale bytes left over from an earlier attempt.
+		f, err = os.Create(tempPath)
+	}
 	if err != nil {
-		return err
+		return "", err
 	}
-	if _, err = io.Copy(f, body); err != nil {
+
+	if _, err = io.Copy(f, resp.Body); err != nil {
 		f.Close()
+		os.Remove(tempPath)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+
+	if verify.length > 0 {
+		if fi, err := os.Stat(tempPath); err != nil {
+			os.Remove(tempPath)
+			return "", err
+		} else if fi.Size() != verify.length {
+			os.Remove(tempPath)
+			return "", fmt.Errorf("downloaded %d bytes, want %d", fi.Size(), verify.length)
+		}
+	}
+	if verify.hashValue != "" {
+		if err := verifyHash(tempPath, verify.hashAlgo, verify.hashValue); err != nil {
+			os.Remove(tempPath)
+			return "", err
+		}
+	}
+
+	if err := os.Rename(tempPath, p); err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+	return p, nil
+}
+
+// verifyHash checks that the file at path hashes to want using algo, which
+// must be "sha-512"/"sha512", "sha-256"/"sha256", or "md5".
+func verifyHash(path, algo, want string) error {
+	var h hash.Hash
+	switch strings.ToLower(algo) {
+	case "sha-512", "sha512":
+		h = sha512.New()
+	case "sha-256", "sha256":
+		h = sha256.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return fmt.Errorf("unsupported hash algorithm %q", algo)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
 		return err
 	}
-	return f.Close()
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, want) {
+		return fmt.Errorf("%v hash mismatch: got %v, want %v", algo, got, want)
+	}
+	return nil
+}
+
+// tagMP3 writes ID3v2 frames to the MP3 file at p using metadata from the
+// feed: TIT2 from it.title, TPE1 from channel.author, TALB from
+// channel.title, TDRC from it.pubDate's year, COMM from it.description, and
+// (if channelImage is non-empty) an APIC frame embedding the channel's cover
+// art.
+func tagMP3(p string, it item, channel channelInfo, channelImage []byte) error {
+	tag, err := id3v2.Open(p, id3v2.Options{Parse: false})
+	if err != nil {
+		return err
+	}
+	defer tag.Close()
+
+	if it.title != "" {
+		tag.SetTitle(it.title)
+	}
+	if channel.author != "" {
+		tag.SetArtist(channel.author)
+	}
+	if channel.title != "" {
+		tag.SetAlbum(channel.title)
+	}
+	if !it.pubDate.IsZero() {
+		tag.SetYear(strconv.Itoa(it.pubDate.Year()))
+	}
+	if it.description != "" {
+		tag.AddCommentFrame(id3v2.CommentFrame{
+			Encoding:    id3v2.EncodingUTF8,
+			Language:    "eng",
+			Description: "",
+			Text:        it.description,
+		})
+	}
+	if len(channelImage) > 0 {
+		tag.AddAttachedPicture(id3v2.PictureFrame{
+			Encoding:    id3v2.EncodingUTF8,
+			MimeType:    http.DetectContentType(channelImage),
+			PictureType: id3v2.PTFrontCover,
+			Description: "Cover",
+			Picture:     channelImage,
+		})
+	}
+
+	return tag.Save()
+}
+
+// fetchChannelImage downloads the channel's cover art, returning nil (and no
+// error) if it doesn't have one.
+func fetchChannelImage(channel channelInfo) ([]byte, error) {
+	if channel.imageURL == "" {
+		return nil, nil
+	}
+	body, err := openURL(channel.imageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// downloadResult describes the outcome of downloading a single item, for
+// logging back on the main goroutine.
+type downloadResult struct {
+	item item
+	err  error
+}
+
+// feedConfig describes a single podcast entry from a -config file (or the
+// synthesized entry for a standalone -feed).
+type feedConfig struct {
+	url    string
+	dir    string // subdirectory of dest, if any
+	prefix string
+	max    int            // max episodes to mirror, or -1 for unlimited
+	filter *regexp.Regexp // if set, only titles matching this are downloaded
+}
+
+// parseConfig reads a minimal TOML-like config file listing podcasts as
+// [[feed]] array-of-table entries, e.g.:
+//
+//	[[feed]]
+//	url = "https://example.com/feed.xml"
+//	dir = "example"
+//	prefix = "ex-"
+//	max = 10
+//	filter = "^Episode"
+//
+// This intentionally supports only the small subset of TOML needed here
+// (string and integer values, no nested tables) rather than pulling in a
+// third-party parser for a single-file tool.
+func parseConfig(path string) ([]feedConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var feeds []feedConfig
+	var cur *feedConfig
+
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "[[feed]]" {
+			feeds = append(feeds, feedConfig{max: -1})
+			cur = &feeds[len(feeds)-1]
+			continue
+		}
+		if cur == nil {
+			return nil, fmt.Errorf("%v:%v: key outside of [[feed]] table", path, n+1)
+		}
+
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%v:%v: malformed line %q", path, n+1, line)
+		}
+		key = strings.TrimSpace(key)
+		val = strings.TrimSpace(val)
+
+		if strings.HasPrefix(val, `"`) {
+			s, err := strconv.Unquote(val)
+			if err != nil {
+				return nil, fmt.Errorf("%v:%v: bad string %q: %v", path, n+1, val, err)
+			}
+			val = s
+		}
+
+		switch key {
+		case "url":
+			cur.url = val
+		case "dir":
+			cur.dir = val
+		case "prefix":
+			cur.prefix = val
+		case "max":
+			m, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("%v:%v: bad max %q: %v", path, n+1, val, err)
+			}
+			cur.max = m
+		case "filter":
+			re, err := regexp.Compile(val)
+			if err != nil {
+				return nil, fmt.Errorf("%v:%v: bad filter %q: %v", path, n+1, val, err)
+			}
+			cur.filter = re
+		default:
+			return nil, fmt.Errorf("%v:%v: unknown key %q", path, n+1, key)
+		}
+	}
+
+	for i, fc := range feeds {
+		if fc.url == "" {
+			return nil, fmt.Errorf("%v: feed %v missing url", path, i+1)
+		}
+	}
+	return feeds, nil
+}
+
+// feedProcessOpts bundles the options shared across every feed being
+// processed in a single run of the program.
+type feedProcessOpts struct {
+	quiet, skip  bool
+	parallel     int
+	limiter      *hostLimiter
+	nameTemplate *template.Template
+	tag          bool
+	// claimer is shared across every feed processed in this run so that
+	// feeds writing into the same destination directory (the default dest
+	// with no per-feed dir, or multiple feeds sharing one) can't race each
+	// other into picking the same filename when feeds run in parallel.
+	claimer *pathClaimer
+}
+
+// processFeed downloads new episodes for a single feed, writing them under
+// filepath.Join(dest, fc.dir) and marking them seen under a subdir keyed on
+// fc.url so state from single-feed mode keeps working once a feed is moved
+// into a config file.
+func processFeed(fc feedConfig, dest string, opts feedProcessOpts) {
+	channel, items, err := getItems(fc.url)
+	if err != nil {
+		log.Printf("Failed to extract items from %v: %v", fc.url, err)
+		return
+	}
+
+	if fc.filter != nil {
+		var filtered []item
+		for _, it := range items {
+			if fc.filter.MatchString(it.title) {
+				filtered = append(filtered, it)
+			}
+		}
+		items = filtered
+	}
+	if fc.max >= 0 && fc.max < len(items) {
+		items = items[:fc.max]
+	}
+
+	destDir := dest
+	if fc.dir != "" {
+		destDir = filepath.Join(dest, fc.dir)
+	}
+
+	var channelImage []byte
+	if opts.tag {
+		if img, err := fetchChannelImage(channel); err != nil {
+			log.Printf("Failed to fetch cover art from %v: %v", channel.imageURL, err)
+		} else {
+			channelImage = img
+		}
+	}
+
+	itemCh := make(chan item)
+	resultCh := make(chan downloadResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range itemCh {
+				err := downloadItem(it, downloadItemOpts{
+					destDir:      destDir,
+					feed:         fc.url,
+					prefix:       fc.prefix,
+					verbose:      !opts.quiet,
+					skipDownload: opts.skip,
+					limiter:      opts.limiter,
+					nameTemplate: opts.nameTemplate,
+					claimer:      opts.claimer,
+					tag:          opts.tag,
+					channel:      channel,
+					channelImage: channelImage,
+				})
+				resultCh <- downloadResult{it, err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, it := range items {
+			itemCh <- it
+		}
+		close(itemCh)
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	for res := range resultCh {
+		if res.err != nil {
+			log.Printf("Failed to download %v: %v", res.item.url, res.err)
+		}
+	}
 }
 
 func main() {
 	dest := flag.String("dest", filepath.Join(os.Getenv("HOME"), "temp/podcasts"), "Directory where files should be saved")
 	feed := flag.String("feed", "", "URL of feed to mirror")
+	config := flag.String("config", "", "Path to TOML config file listing multiple feeds")
 	prefix := flag.String("prefix", "", "Prefix to prepend to filenames")
 	quiet := flag.Bool("quiet", false, "Suppress informational logging")
 	skip := flag.Bool("skip", false, "Mark files as downloaded without downloading")
 	num := flag.Int("num", -1, "Maximum number of files to mirror")
+	parallel := flag.Int("parallel", 1, "Number of episodes to download concurrently")
+	feedParallel := flag.Int("feed-parallel", 1, "Number of feeds to process concurrently")
+	nameTemplateFlag := flag.String("name-template", "", "Go text/template for naming files, e.g. "+
+		`'{{.Date.Format "2006-01-02"}} {{.Title}}.{{.Ext}}'`)
+	tag := flag.Bool("tag", false, "Write ID3v2 tags to downloaded MP3s using feed metadata")
 	flag.Parse()
 
-	if *feed == "" {
-		log.Fatal("-feed must be supplied")
+	if (*feed == "") == (*config == "") {
+		log.Fatal("exactly one of -feed or -config must be supplied")
 	}
-	items, err := getItems(*feed)
-	if err != nil {
-		log.Fatalf("Failed to extract items from %v: %v", *feed, err)
+	if *parallel < 1 {
+		log.Fatal("-parallel must be at least 1")
+	}
+	if *feedParallel < 1 {
+		log.Fatal("-feed-parallel must be at least 1")
 	}
 
-	for i, item := range items {
-		if *num >= 0 && i >= *num {
-			break
+	var nameTemplate *template.Template
+	if *nameTemplateFlag != "" {
+		t, err := template.New("name").Parse(*nameTemplateFlag)
+		if err != nil {
+			log.Fatalf("Failed to parse -name-template: %v", err)
 		}
-		if err = downloadItem(item, *dest, *feed, *prefix, !*quiet, *skip); err != nil {
-			log.Printf("Failed to download %v: %v", item.url, err)
+		nameTemplate = t
+	}
+
+	var feeds []feedConfig
+	if *config != "" {
+		fcs, err := parseConfig(*config)
+		if err != nil {
+			log.Fatalf("Failed to parse %v: %v", *config, err)
 		}
+		feeds = fcs
+	} else {
+		feeds = []feedConfig{{url: *feed, prefix: *prefix, max: *num}}
+	}
+
+	opts := feedProcessOpts{
+		quiet:        *quiet,
+		skip:         *skip,
+		parallel:     *parallel,
+		limiter:      newHostLimiter(),
+		nameTemplate: nameTemplate,
+		tag:          *tag,
+		claimer:      newPathClaimer(),
+	}
+	// Feeds share opts.limiter, so episodes across different feeds that
+	// happen to resolve to the same host are still rate-limited together.
+	// They also share opts.claimer, so feeds writing into the same
+	// destination directory don't race each other into the same filename.
+	sem := make(chan struct{}, *feedParallel)
+	var wg sync.WaitGroup
+	for _, fc := range feeds {
+		fc := fc
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			processFeed(fc, *dest, opts)
+		}()
 	}
+	wg.Wait()
 }